@@ -0,0 +1,186 @@
+package lokigrus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/MouseHatGames/lokigrus/internal/logproto"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Protocol selects the wire format and endpoint used to push batches to Loki.
+type Protocol int
+
+const (
+	// ProtocolProtobufV0 posts snappy-compressed protobuf to the legacy
+	// /api/prom/push endpoint. This is the default, for backward compatibility.
+	ProtocolProtobufV0 Protocol = iota
+
+	// ProtocolProtobufV1 posts snappy-compressed protobuf to
+	// /loki/api/v1/push.
+	ProtocolProtobufV1
+
+	// ProtocolJSONV1 posts the documented JSON body to /loki/api/v1/push.
+	ProtocolJSONV1
+)
+
+const pushPathV1 = "/loki/api/v1/push"
+
+// WithProtocol selects the wire protocol used to push batches. The default
+// is ProtocolProtobufV0.
+func WithProtocol(p Protocol) Option {
+	return func(h *Writer) {
+		h.protocol = p
+	}
+}
+
+// Compression selects how the JSON v1 push body is compressed on the wire.
+// Protobuf payloads are always snappy-compressed, since that's the format
+// Loki's legacy and v1 protobuf endpoints expect; Compression only affects
+// ProtocolJSONV1.
+type Compression int
+
+const (
+	// CompressionNone sends the JSON body uncompressed. This is the default.
+	CompressionNone Compression = iota
+	CompressionGzip
+
+	// CompressionSnappy is a no-op for ProtocolJSONV1: Loki's JSON push
+	// endpoint only understands gzip/deflate Content-Encoding, so snappy
+	// is protobuf-specific and the JSON body is sent uncompressed.
+	CompressionSnappy
+)
+
+// WithCompression selects how JSON v1 payloads are compressed on the wire.
+// It has no effect on the protobuf protocols, which are always
+// snappy-compressed.
+func WithCompression(c Compression) Option {
+	return func(h *Writer) {
+		h.compression = c
+	}
+}
+
+// pushEncoder encodes a stream's entries into a request body, and reports
+// the endpoint path and headers it must be sent with.
+type pushEncoder interface {
+	path() string
+	contentType() string
+	contentEncoding() string
+	encode(labels map[string]string, entries []*entry) ([]byte, error)
+}
+
+func encoderFor(p Protocol, compression Compression) pushEncoder {
+	switch p {
+	case ProtocolProtobufV1:
+		return protobufEncoder{endpoint: pushPathV1}
+	case ProtocolJSONV1:
+		return jsonEncoder{compression: compression}
+	default:
+		return protobufEncoder{endpoint: postPath}
+	}
+}
+
+// protobufEncoder encodes entries as a snappy-compressed logproto.PushRequest,
+// preserving nanosecond-precision timestamps. Entries are split into one
+// StreamAdapter per distinct (base-merged-with-per-entry) label set.
+type protobufEncoder struct {
+	endpoint string
+}
+
+func (e protobufEncoder) path() string            { return e.endpoint }
+func (e protobufEncoder) contentType() string     { return "application/x-protobuf" }
+func (e protobufEncoder) contentEncoding() string { return "" }
+
+func (e protobufEncoder) encode(labels map[string]string, entries []*entry) ([]byte, error) {
+	groups := groupByLabels(labels, entries)
+
+	streams := make([]*logproto.StreamAdapter, len(groups))
+	for i, g := range groups {
+		adapterEntries := make([]*logproto.EntryAdapter, len(g.entries))
+		for j, en := range g.entries {
+			adapterEntries[j] = &logproto.EntryAdapter{
+				Timestamp: timestamppb.New(en.time),
+				Line:      en.str,
+			}
+		}
+
+		streams[i] = &logproto.StreamAdapter{
+			Labels:  formatLabels(g.labels),
+			Entries: adapterEntries,
+		}
+	}
+
+	req := logproto.PushRequest{Streams: streams}
+
+	b, err := proto.Marshal(&req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal push request: %s", err)
+	}
+
+	return snappy.Encode(nil, b), nil
+}
+
+// jsonEncoder encodes entries as the documented v1 JSON push body:
+// {"streams":[{"stream":{...},"values":[["<ns>","<line>"]]}]}. Entries are
+// split into one stream per distinct (base-merged-with-per-entry) label set.
+type jsonEncoder struct {
+	compression Compression
+}
+
+func (jsonEncoder) path() string        { return pushPathV1 }
+func (jsonEncoder) contentType() string { return "application/json" }
+
+func (e jsonEncoder) contentEncoding() string {
+	if e.compression == CompressionGzip {
+		return "gzip"
+	}
+	return ""
+}
+
+func (e jsonEncoder) encode(labels map[string]string, entries []*entry) ([]byte, error) {
+	groups := groupByLabels(labels, entries)
+
+	streams := make([]jsonStream, len(groups))
+	for i, g := range groups {
+		values := make([][2]string, len(g.entries))
+		for j, en := range g.entries {
+			values[j] = [2]string{strconv.FormatInt(en.time.UnixNano(), 10), en.str}
+		}
+		streams[i] = jsonStream{Stream: g.labels, Values: values}
+	}
+
+	body := jsonPushRequest{Streams: streams}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal push request: %s", err)
+	}
+
+	if e.compression == CompressionGzip {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(b); err != nil {
+			return nil, fmt.Errorf("gzip push request: %s", err)
+		}
+		if err := gw.Close(); err != nil {
+			return nil, fmt.Errorf("gzip push request: %s", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	return b, nil
+}
+
+type jsonPushRequest struct {
+	Streams []jsonStream `json:"streams"`
+}
+
+type jsonStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
@@ -0,0 +1,46 @@
+package lokigrus
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJSONEncoderEncode(t *testing.T) {
+	enc := jsonEncoder{}
+
+	e1 := &entry{time: time.Unix(0, 100), str: `{"msg":"a"}`}
+	e2 := &entry{time: time.Unix(0, 200), str: `{"msg":"b"}`, labels: map[string]string{"level": "error"}}
+
+	b, err := enc.encode(map[string]string{"app": "foo"}, []*entry{e1, e2})
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	var got jsonPushRequest
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal encoded body: %s", err)
+	}
+
+	if len(got.Streams) != 2 {
+		t.Fatalf("expected 2 streams, got %d", len(got.Streams))
+	}
+	if got.Streams[0].Stream["app"] != "foo" || got.Streams[0].Values[0][1] != `{"msg":"a"}` {
+		t.Errorf("unexpected first stream: %+v", got.Streams[0])
+	}
+	if got.Streams[1].Stream["level"] != "error" || got.Streams[1].Values[0][1] != `{"msg":"b"}` {
+		t.Errorf("unexpected second stream: %+v", got.Streams[1])
+	}
+}
+
+func TestJSONEncoderContentEncoding(t *testing.T) {
+	if got := (jsonEncoder{compression: CompressionNone}).contentEncoding(); got != "" {
+		t.Errorf("CompressionNone contentEncoding = %q, want empty", got)
+	}
+	if got := (jsonEncoder{compression: CompressionGzip}).contentEncoding(); got != "gzip" {
+		t.Errorf("CompressionGzip contentEncoding = %q, want gzip", got)
+	}
+	if got := (jsonEncoder{compression: CompressionSnappy}).contentEncoding(); got != "" {
+		t.Errorf("CompressionSnappy contentEncoding = %q, want empty (unsupported for JSON)", got)
+	}
+}
@@ -6,9 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
-	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,21 +16,35 @@ const postPath = "/api/prom/push"
 var ErrInvalidJSON = errors.New("invalid json written")
 
 type entry struct {
-	time int64
-	str  string
+	time   time.Time
+	str    string
+	labels map[string]string
 }
 
 type Writer struct {
-	Out           io.Writer
-	LokiURL       string
-	MaxBatchAge   time.Duration
-	MaxBatchCount int
-	CheckJSON     bool
-	labels        string
-
-	lineChan chan *entry
-	batch    []*entry
-	maxTime  *time.Timer
+	Out            io.Writer
+	MaxBatchAge    time.Duration
+	MaxBatchCount  int
+	MaxBatchBytes  int
+	CheckJSON      bool
+	baseLabels     map[string]string
+	rawTargets     []Target
+	protocol       Protocol
+	compression    Compression
+	labelsFromJSON []string
+
+	retry        RetryConfig
+	deadLetter   io.Writer
+	deadLetterMu sync.Mutex
+
+	targets    []resolvedTarget
+	lineChan   chan *entry
+	batch      []*entry
+	batchBytes int
+	maxTime    *time.Timer
+	done       chan struct{}
+	retryChan  chan *retryJob
+	retryWG    sync.WaitGroup
 }
 
 type Option func(*Writer)
@@ -51,10 +64,21 @@ func MaxBatchCount(count int) Option {
 	}
 }
 
-// Data is the labels to be attached to the Loki stream
+// MaxBatchBytes is the maximum cumulative size, in bytes, of the log lines
+// in a batch. The batch is flushed before adding an entry that would push it
+// over the threshold. A single entry larger than MaxBatchBytes is still
+// sent on its own, so the limit can be exceeded by one oversized entry.
+func MaxBatchBytes(n int) Option {
+	return func(h *Writer) {
+		h.MaxBatchBytes = n
+	}
+}
+
+// Data is the base labels attached to every stream sent to every target,
+// merged with (and overridden by) each target's own Labels.
 func Data(data map[string]string) Option {
 	return func(h *Writer) {
-		h.labels = formatLabels(data)
+		h.baseLabels = data
 	}
 }
 
@@ -72,46 +96,80 @@ func CheckJSON(check bool) Option {
 	}
 }
 
-// NewWriter creates a new writer that sends the data written into it to a Loki instance.
+// WithLabelsFromJSON auto-promotes the named top-level fields of each JSON
+// line (e.g. "level", "logger") into stream labels, in addition to any
+// labels passed to WriteWithLabels.
+func WithLabelsFromJSON(fields []string) Option {
+	return func(h *Writer) {
+		h.labelsFromJSON = fields
+	}
+}
+
+// NewWriter creates a new writer that sends the data written into it to a
+// single Loki instance. It is a thin wrapper around WithTargets for the
+// common single-endpoint case.
 func NewWriter(lokiURL string, opts ...Option) *Writer {
+	opts = append([]Option{WithTargets(Target{URL: lokiURL})}, opts...)
+	return newWriter(opts...)
+}
+
+func newWriter(opts ...Option) *Writer {
 	h := &Writer{
 		Out:           os.Stdout,
-		LokiURL:       lokiURL,
 		MaxBatchAge:   30 * time.Second,
 		MaxBatchCount: 5,
 		CheckJSON:     true,
 		lineChan:      make(chan *entry, 5),
+		done:          make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt(h)
 	}
 
-	u, err := url.Parse(h.LokiURL)
-	if err != nil {
-		panic(err)
+	if len(h.rawTargets) == 0 {
+		panic("at least one target must be set")
 	}
-	if !strings.Contains(u.Path, postPath) {
-		u.Path = postPath
-		q := u.Query()
-		u.RawQuery = q.Encode()
-		h.LokiURL = u.String()
+
+	h.targets = make([]resolvedTarget, len(h.rawTargets))
+	for i, t := range h.rawTargets {
+		h.targets[i] = newResolvedTarget(t, h.protocol)
 	}
 
-	if h.labels == "" {
+	if !hasLabels(h.baseLabels, h.targets, h.labelsFromJSON) {
 		panic("data must be set")
 	}
 
+	if h.retry.MaxAttempts > 0 {
+		h.startRetryWorkers()
+	}
+
 	go h.start()
 	return h
 }
 
 func (l *Writer) Write(b []byte) (n int, err error) {
+	return l.writeEntry(nil, b)
+}
+
+// WriteWithLabels behaves like Write, but attaches additional labels to the
+// stream this entry is sent on, on top of the writer's and targets' own
+// labels. Useful for logrus hooks that want to carry fields such as level,
+// logger, or trace IDs as Loki labels instead of just JSON body content.
+func (l *Writer) WriteWithLabels(labels map[string]string, b []byte) (n int, err error) {
+	return l.writeEntry(labels, b)
+}
+
+func (l *Writer) writeEntry(labels map[string]string, b []byte) (n int, err error) {
 	if l.CheckJSON && !json.Valid(b) {
 		return 0, ErrInvalidJSON
 	}
 
-	l.lineChan <- &entry{time.Now().Unix(), string(b)}
+	if len(l.labelsFromJSON) > 0 {
+		labels = mergeLabels(extractJSONLabels(l.labelsFromJSON, b), labels)
+	}
+
+	l.lineChan <- &entry{time.Now(), string(b), labels}
 
 	if l.Out != nil {
 		return l.Out.Write(b)
@@ -119,9 +177,17 @@ func (l *Writer) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
-// Close closes the input channel.
+// Close closes the input channel, waits for the final batch to be flushed,
+// and drains any in-flight retries before returning.
 func (l *Writer) Close() error {
 	close(l.lineChan)
+	<-l.done
+
+	if l.retryChan != nil {
+		close(l.retryChan)
+		l.retryWG.Wait()
+	}
+
 	return nil
 }
 
@@ -133,6 +199,7 @@ func (l *Writer) Flush() {
 func (l *Writer) start() {
 	l.maxTime = time.NewTimer(l.MaxBatchAge)
 
+	defer close(l.done)
 	defer l.mustSendBatch()
 
 loop:
@@ -143,7 +210,12 @@ loop:
 				break loop
 			}
 
+			if l.MaxBatchBytes > 0 && len(l.batch) > 0 && l.batchBytes+len(ll.str) > l.MaxBatchBytes {
+				l.mustSendBatch()
+			}
+
 			l.batch = append(l.batch, ll)
+			l.batchBytes += len(ll.str)
 
 			if len(l.batch) >= l.MaxBatchCount {
 				l.mustSendBatch()
@@ -165,11 +237,25 @@ func (l *Writer) mustSendBatch() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err := sendBatch(ctx, l.batch, l.labels, l.LokiURL)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to send batch: %s\n", err)
+	enc := encoderFor(l.protocol, l.compression)
+
+	var firstErr error
+	for i, err := range sendBatch(ctx, l.batch, l.baseLabels, l.targets, enc) {
+		if err == nil {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "failed to send batch to %s: %s\n", l.targets[i].URL, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if l.retry.MaxAttempts > 0 {
+			l.enqueueRetry(l.batch, l.targets[i])
+		}
 	}
 
 	l.batch = nil
-	return err
+	l.batchBytes = 0
+	return firstErr
 }
@@ -2,6 +2,7 @@ package lokigrus
 
 import (
 	"bytes"
+	"encoding/json"
 	"sort"
 	"strconv"
 )
@@ -28,3 +29,88 @@ func formatLabels(l map[string]string) string {
 	b.WriteByte('}')
 	return b.String()
 }
+
+// mergeLabels returns a new map containing base with override's keys taking
+// precedence on conflicts.
+func mergeLabels(base, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// hasLabels reports whether base or any target has at least one label set,
+// or whether labels will instead be promoted per entry from JSON fields.
+func hasLabels(base map[string]string, targets []resolvedTarget, labelsFromJSON []string) bool {
+	if len(base) > 0 || len(labelsFromJSON) > 0 {
+		return true
+	}
+	for _, t := range targets {
+		if len(t.Labels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// labelGroup is a set of entries that share the same fully-rendered label
+// set, destined for the same logproto stream.
+type labelGroup struct {
+	labels  map[string]string
+	entries []*entry
+}
+
+// groupByLabels groups entries by base merged with each entry's own labels,
+// preserving the order each distinct label set was first seen in.
+func groupByLabels(base map[string]string, entries []*entry) []labelGroup {
+	order := make([]string, 0, 1)
+	groups := make(map[string]*labelGroup, 1)
+
+	for _, e := range entries {
+		merged := mergeLabels(base, e.labels)
+		key := formatLabels(merged)
+
+		g, ok := groups[key]
+		if !ok {
+			g = &labelGroup{labels: merged}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.entries = append(g.entries, e)
+	}
+
+	result := make([]labelGroup, len(order))
+	for i, key := range order {
+		result[i] = *groups[key]
+	}
+	return result
+}
+
+// extractJSONLabels pulls fields out of a JSON line and returns them as
+// labels. Fields that are missing, or whose value isn't a JSON string, are
+// skipped.
+func extractJSONLabels(fields []string, b []byte) map[string]string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil
+	}
+
+	labels := make(map[string]string, len(fields))
+	for _, f := range fields {
+		v, ok := raw[f]
+		if !ok {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			continue
+		}
+		labels[f] = s
+	}
+	return labels
+}
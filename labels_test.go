@@ -0,0 +1,70 @@
+package lokigrus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeLabels(t *testing.T) {
+	base := map[string]string{"app": "foo", "env": "prod"}
+	override := map[string]string{"env": "staging", "level": "info"}
+
+	got := mergeLabels(base, override)
+	want := map[string]string{"app": "foo", "env": "staging", "level": "info"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeLabels = %v, want %v", got, want)
+	}
+}
+
+func TestHasLabels(t *testing.T) {
+	cases := []struct {
+		name           string
+		base           map[string]string
+		targets        []resolvedTarget
+		labelsFromJSON []string
+		want           bool
+	}{
+		{"no labels anywhere", nil, nil, nil, false},
+		{"base labels", map[string]string{"app": "foo"}, nil, nil, true},
+		{"target labels", nil, []resolvedTarget{{Target: Target{Labels: map[string]string{"env": "prod"}}}}, nil, true},
+		{"labels from json", nil, nil, []string{"level"}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := hasLabels(c.base, c.targets, c.labelsFromJSON); got != c.want {
+				t.Errorf("hasLabels() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestGroupByLabels(t *testing.T) {
+	a := &entry{str: "a", labels: map[string]string{"level": "info"}}
+	b := &entry{str: "b", labels: map[string]string{"level": "error"}}
+	c := &entry{str: "c", labels: map[string]string{"level": "info"}}
+
+	groups := groupByLabels(map[string]string{"app": "foo"}, []*entry{a, b, c})
+
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	if groups[0].labels["level"] != "info" || len(groups[0].entries) != 2 {
+		t.Errorf("first group should be the 2 info entries, got %+v", groups[0])
+	}
+	if groups[1].labels["level"] != "error" || len(groups[1].entries) != 1 {
+		t.Errorf("second group should be the 1 error entry, got %+v", groups[1])
+	}
+}
+
+func TestExtractJSONLabels(t *testing.T) {
+	b := []byte(`{"level":"info","count":5,"msg":"hello"}`)
+
+	got := extractJSONLabels([]string{"level", "count", "missing"}, b)
+	want := map[string]string{"level": "info"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractJSONLabels = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package lokigrus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maxRetryWorkers bounds how many batches can be retried concurrently, so a
+// burst of failures can't spawn an unbounded number of goroutines.
+const maxRetryWorkers = 4
+
+// RetryConfig controls how a batch that failed to push is retried before
+// being handed off to the dead-letter writer.
+type RetryConfig struct {
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+}
+
+// retryJob is a batch that failed its initial send to a target and is
+// queued for retry against that same target.
+type retryJob struct {
+	entries    []*entry
+	baseLabels map[string]string
+	target     resolvedTarget
+}
+
+// deadLetterEntry is the JSONL representation written for a batch whose
+// retries were exhausted.
+type deadLetterEntry struct {
+	Labels    string    `json:"labels"`
+	Timestamp time.Time `json:"timestamp"`
+	Line      string    `json:"line"`
+}
+
+// WithRetry retries failed batch pushes with exponential backoff and jitter
+// between min and max, up to maxAttempts times. Retries are attempted for
+// 5xx/429 responses and network errors; other 4xx responses fail immediately.
+// Retries run in a bounded worker pool so they never block the batching loop.
+func WithRetry(min, max time.Duration, maxAttempts int) Option {
+	return func(h *Writer) {
+		h.retry = RetryConfig{MinBackoff: min, MaxBackoff: max, MaxAttempts: maxAttempts}
+	}
+}
+
+// WithDeadLetter sets the writer that batches are serialized to (one JSON
+// object per line) once their retries are exhausted.
+func WithDeadLetter(w io.Writer) Option {
+	return func(h *Writer) {
+		h.deadLetter = w
+	}
+}
+
+func (l *Writer) startRetryWorkers() {
+	l.retryChan = make(chan *retryJob, maxRetryWorkers)
+
+	for i := 0; i < maxRetryWorkers; i++ {
+		l.retryWG.Add(1)
+		go l.retryWorker()
+	}
+}
+
+func (l *Writer) retryWorker() {
+	defer l.retryWG.Done()
+
+	for job := range l.retryChan {
+		l.retryBatch(job)
+	}
+}
+
+// enqueueRetry hands a batch that failed against target off to the retry
+// workers. If the queue is full the batch is dead-lettered immediately
+// rather than blocking the caller.
+func (l *Writer) enqueueRetry(batch []*entry, target resolvedTarget) {
+	job := &retryJob{entries: batch, baseLabels: l.baseLabels, target: target}
+
+	select {
+	case l.retryChan <- job:
+	default:
+		fmt.Fprintln(os.Stderr, "retry queue full, dead-lettering batch")
+		l.writeDeadLetter(job)
+	}
+}
+
+func (l *Writer) retryBatch(job *retryJob) {
+	enc := encoderFor(l.protocol, l.compression)
+
+	var err error
+
+	for attempt := 0; attempt < l.retry.MaxAttempts; attempt++ {
+		time.Sleep(retryDelay(l.retry.MinBackoff, l.retry.MaxBackoff, attempt, err))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = sendToTarget(ctx, job.entries, job.baseLabels, job.target, enc)
+		cancel()
+
+		if err == nil {
+			return
+		}
+		if !isRetryableErr(err) {
+			break
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "giving up on batch to %s after retries: %s\n", job.target.URL, err)
+	l.writeDeadLetter(job)
+}
+
+func (l *Writer) writeDeadLetter(job *retryJob) {
+	if l.deadLetter == nil {
+		return
+	}
+
+	l.deadLetterMu.Lock()
+	defer l.deadLetterMu.Unlock()
+
+	targetLabels := mergeLabels(job.baseLabels, job.target.Labels)
+
+	enc := json.NewEncoder(l.deadLetter)
+	for _, e := range job.entries {
+		err := enc.Encode(deadLetterEntry{
+			Labels:    formatLabels(mergeLabels(targetLabels, e.labels)),
+			Timestamp: e.time,
+			Line:      e.str,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write dead letter entry: %s\n", err)
+		}
+	}
+}
+
+// retryDelay computes the exponential backoff delay (with jitter) for the
+// given attempt number, honoring a server-provided Retry-After if lastErr
+// carries one.
+func retryDelay(min, max time.Duration, attempt int, lastErr error) time.Duration {
+	d := min << attempt
+	if d <= 0 || d > max {
+		d = max
+	}
+
+	delay := min + time.Duration(rand.Int63n(int64(d-min+1)))
+
+	var he *httpError
+	if errors.As(lastErr, &he) && he.RetryAfter > delay {
+		delay = he.RetryAfter
+	}
+
+	return delay
+}
+
+func isRetryableErr(err error) bool {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.StatusCode == http.StatusTooManyRequests || he.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,114 @@
+package lokigrus
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableErr(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"429", &httpError{StatusCode: http.StatusTooManyRequests}, true},
+		{"500", &httpError{StatusCode: http.StatusInternalServerError}, true},
+		{"503", &httpError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"400", &httpError{StatusCode: http.StatusBadRequest}, false},
+		{"404", &httpError{StatusCode: http.StatusNotFound}, false},
+		{"wrapped 503", fmt.Errorf("send push request: %w", &httpError{StatusCode: 503}), true},
+		{"wrapped 400", fmt.Errorf("send push request: %w", &httpError{StatusCode: 400}), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryableErr(c.err); got != c.want {
+				t.Errorf("isRetryableErr(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	d := retryDelay(100*time.Millisecond, time.Second, 0, nil)
+	if d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("retryDelay out of bounds: %s", d)
+	}
+
+	he := &httpError{RetryAfter: 5 * time.Second}
+	if d := retryDelay(100*time.Millisecond, time.Second, 0, he); d != 5*time.Second {
+		t.Errorf("retryDelay should honor Retry-After, got %s", d)
+	}
+}
+
+// TestRetryBatchSucceedsAfterTransientFailures covers the bug where errors
+// returned up through sendToTarget weren't wrapped with %w, so
+// isRetryableErr could never unwrap to *httpError and every failure was
+// treated as permanent after a single attempt.
+func TestRetryBatchSucceedsAfterTransientFailures(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var deadLettered bytes.Buffer
+	l := &Writer{
+		protocol:   ProtocolJSONV1,
+		deadLetter: &deadLettered,
+		retry:      RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, MaxAttempts: 5},
+	}
+
+	job := &retryJob{
+		entries: []*entry{{time: time.Now(), str: `{"msg":"hi"}`}},
+		target:  resolvedTarget{Target: Target{URL: srv.URL}, url: srv.URL, client: http.DefaultClient},
+	}
+
+	l.retryBatch(job)
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("expected 3 attempts (2 failures + 1 success), got %d", got)
+	}
+	if deadLettered.Len() != 0 {
+		t.Errorf("batch should not be dead-lettered after eventual success, got %q", deadLettered.String())
+	}
+}
+
+func TestRetryBatchStopsOnNonRetryableError(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	var deadLettered bytes.Buffer
+	l := &Writer{
+		protocol:   ProtocolJSONV1,
+		deadLetter: &deadLettered,
+		retry:      RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, MaxAttempts: 5},
+	}
+
+	job := &retryJob{
+		entries: []*entry{{time: time.Now(), str: `{"msg":"hi"}`}},
+		target:  resolvedTarget{Target: Target{URL: srv.URL}, url: srv.URL, client: http.DefaultClient},
+	}
+
+	l.retryBatch(job)
+
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected exactly 1 attempt before giving up on a 400, got %d", got)
+	}
+	if deadLettered.Len() == 0 {
+		t.Error("batch should be dead-lettered after a non-retryable error")
+	}
+}
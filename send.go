@@ -7,56 +7,77 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-
-	"github.com/MouseHatGames/lokigrus/internal/logproto"
-	"github.com/golang/snappy"
-	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"strconv"
+	"sync"
+	"time"
 )
 
-func sendBatch(ctx context.Context, entries []*entry, labels string, lokiURL string) error {
-	adapterEntries := make([]*logproto.EntryAdapter, len(entries))
+// sendBatch pushes entries to every target in parallel using enc, returning
+// one error per target (nil on success) in the same order as targets.
+func sendBatch(ctx context.Context, entries []*entry, baseLabels map[string]string, targets []resolvedTarget, enc pushEncoder) []error {
+	errs := make([]error, len(targets))
 
-	for i, e := range entries {
-		adapterEntries[i] = &logproto.EntryAdapter{
-			Timestamp: &timestamppb.Timestamp{Seconds: e.time},
-			Line:      e.str,
-		}
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t resolvedTarget) {
+			defer wg.Done()
+			errs[i] = sendToTarget(ctx, entries, baseLabels, t, enc)
+		}(i, t)
 	}
+	wg.Wait()
 
-	req := logproto.PushRequest{
-		Streams: []*logproto.StreamAdapter{
-			{
-				Labels:  labels,
-				Entries: adapterEntries,
-			},
-		},
-	}
+	return errs
+}
+
+func sendToTarget(ctx context.Context, entries []*entry, baseLabels map[string]string, t resolvedTarget, enc pushEncoder) error {
+	labels := mergeLabels(baseLabels, t.Labels)
 
-	b, err := proto.Marshal(&req)
+	b, err := enc.encode(labels, entries)
 	if err != nil {
-		return fmt.Errorf("format push request: %s", err)
+		return fmt.Errorf("format push request: %w", err)
 	}
 
-	b = snappy.Encode(nil, b)
-
-	_, err = send(ctx, lokiURL, b)
+	_, err = send(ctx, t, b, enc.contentType(), enc.contentEncoding())
 	if err != nil {
-		return fmt.Errorf("send push request: %s", err)
+		return fmt.Errorf("send push request: %w", err)
 	}
 
 	return nil
 }
 
-func send(ctx context.Context, lokiURL string, buf []byte) (int, error) {
-	req, err := http.NewRequest("POST", lokiURL, bytes.NewReader(buf))
+// httpError is returned by send when Loki responds with a non-2xx status,
+// so callers can tell retryable errors (5xx, 429) apart from the rest.
+type httpError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %s (%d): %s", e.Status, e.StatusCode, e.Body)
+}
+
+func send(ctx context.Context, t resolvedTarget, buf []byte, contentType, contentEncoding string) (int, error) {
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(buf))
 	if err != nil {
 		return -1, err
 	}
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	if t.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", t.TenantID)
+	}
+	if t.BasicAuth != nil {
+		req.SetBasicAuth(t.BasicAuth.Username, t.BasicAuth.Password)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := t.client.Do(req)
 	if err != nil {
 		return -1, err
 	}
@@ -68,7 +89,22 @@ func send(ctx context.Context, lokiURL string, buf []byte) (int, error) {
 		if scanner.Scan() {
 			line = scanner.Text()
 		}
-		err = fmt.Errorf("server returned HTTP status %s (%d): %s", resp.Status, resp.StatusCode, line)
+		return resp.StatusCode, &httpError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       line,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// parseRetryAfter parses a Retry-After header, which Loki sends as a number
+// of seconds. An empty or malformed value yields no delay.
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		return 0
 	}
-	return resp.StatusCode, err
+	return time.Duration(secs) * time.Second
 }
@@ -0,0 +1,74 @@
+package lokigrus
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Target is a single Loki endpoint that batches are pushed to.
+type Target struct {
+	// URL is the Loki instance's base URL, e.g. http://localhost:3100.
+	URL string
+
+	// Labels are merged with the writer's base labels (set via Data) for
+	// every stream sent to this target, taking precedence on conflicts.
+	Labels map[string]string
+
+	// TenantID, if set, is sent as the X-Scope-OrgID header for this target.
+	TenantID string
+
+	// BasicAuth, if set, authenticates pushes to this target.
+	BasicAuth *BasicAuth
+
+	// TLSConfig, if set, configures the HTTP client used for this target.
+	TLSConfig *tls.Config
+}
+
+// BasicAuth holds HTTP basic auth credentials for a Target.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// WithTargets sets the Loki endpoints that batches are pushed to. Every
+// flush is sent to all targets in parallel.
+func WithTargets(targets ...Target) Option {
+	return func(h *Writer) {
+		h.rawTargets = targets
+	}
+}
+
+// resolvedTarget is a Target with its push URL normalized and its HTTP
+// client resolved, computed once at writer construction.
+type resolvedTarget struct {
+	Target
+	url    string
+	client *http.Client
+}
+
+func newResolvedTarget(t Target, protocol Protocol) resolvedTarget {
+	// Compression only affects how the body is encoded, not the endpoint
+	// path, so it doesn't matter which one we pass here.
+	path := encoderFor(protocol, CompressionNone).path()
+
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		panic(err)
+	}
+	if !strings.Contains(u.Path, path) {
+		u.Path = path
+		q := u.Query()
+		u.RawQuery = q.Encode()
+	}
+
+	client := http.DefaultClient
+	if t.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = t.TLSConfig
+		client = &http.Client{Transport: transport}
+	}
+
+	return resolvedTarget{Target: t, url: u.String(), client: client}
+}